@@ -1,18 +1,13 @@
 package main
 
 import (
-	"bufio"
-	"database/sql"
-	"encoding/csv"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
-
-	_ "github.com/lib/pq"
-	// MIT license so its fine to use.
-	"github.com/montanaflynn/stats"
 )
 
 // Given this is only suppose to run in docker, the db info is hardcoded. This would need to be
@@ -33,11 +28,13 @@ type Input struct {
 	endtime   time.Time
 }
 
-// struct to allow easy return of stats from workers
+// struct to allow easy return of stats from workers. queryTimes is only
+// populated in -exact-stats mode; digest is only populated otherwise.
 type Stats struct {
 	totalQueries int64
 	totalTime    time.Duration
 	queryTimes   []time.Duration
+	digest       *tDigest
 }
 
 // query template for workers. We may want to move this somewhere else in the future.
@@ -48,157 +45,154 @@ func main() {
 
 	fileName := flag.String("file", "input/query_params.csv", "location of input file")
 	useStdIn := flag.Bool("stdin", false, "set to true if piping input through stdin")
-	numThreads := *flag.Int("workers", 1, "number of wocker threads")
+	workersFlag := flag.String("workers", "auto", "number of worker threads, or 'auto' to use runtime.NumCPU()")
+	sweep := flag.Bool("sweep", false, "run the input through worker counts 1,2,4,... (doubling, up to NumCPU*2) and print a scaling table")
+
+	hist := flag.Bool("hist", false, "print a latency histogram in addition to the summary stats (implies -exact-stats)")
+	histBuckets := flag.Int("hist-buckets", 10, "number of log-spaced buckets to use for -hist")
+
+	exactStats := flag.Bool("exact-stats", false, "aggregate exact per-query latencies instead of a streaming t-digest; fine for small runs but unbounded memory")
+	compression := flag.Float64("compression", 100, "t-digest compression (delta); higher is more accurate and uses more centroids")
 
-	addData := flag.Bool("add_data", false, "if true adds the expected data and returns")
+	backend := flag.String("backend", "postgres", "query backend to benchmark: postgres, influx")
+	pgDSN := flag.String("pg-dsn", "", "postgres connection string, defaults to the hardcoded db info above")
+	influxURL := flag.String("influx-url", "http://localhost:8086", "influxdb http address, used when -backend=influx")
+	influxDB := flag.String("influx-db", dbname, "influxdb database name, used when -backend=influx")
+
+	addData := flag.Bool("add_data", false, "if true runs the bulk loader against data-file and returns")
+	dataFile := flag.String("data-file", "/app/input/cpu_usage.csv", "location of the bulk load input file")
+	loaderWorkers := flag.Int("loader-workers", 4, "number of parallel COPY workers used by the bulk loader")
+	batchSize := flag.Int("batch-size", 5000, "number of rows per COPY batch used by the bulk loader")
+	skipHeader := flag.Bool("skip-header", true, "skip the first row of the bulk load input file")
+	dryRun := flag.Bool("dry-run", false, "parse and batch the bulk load input without writing to the db")
+	truncate := flag.Bool("truncate", false, "truncate cpu_usage before bulk loading")
 
 	flag.Parse()
 
-	// See comment on addDataToDB
+	// See comment on runBulkLoad
 	if *addData {
-		addDataToDB()
+		err := runBulkLoad(bulkLoadConfig{
+			inputFile:  *dataFile,
+			workers:    *loaderWorkers,
+			batchSize:  *batchSize,
+			skipHeader: *skipHeader,
+			dryRun:     *dryRun,
+			truncate:   *truncate,
+		})
+		if err != nil {
+			fmt.Printf("bulk load failed: %s\n", err)
+			os.Exit(1)
+		}
 		return
 	}
 
-	// validate input
-	if numThreads <= 0 {
-		fmt.Println("workers must be a positive number")
-		return
+	backendCfg := backendConfig{
+		backend:   *backend,
+		pgDSN:     *pgDSN,
+		influxURL: *influxURL,
+		influxDB:  *influxDB,
+	}
+	if backendCfg.pgDSN == "" {
+		backendCfg.pgDSN = buildDSN()
 	}
 
-	// The requirement was that 1) use a variable number of workers and 2) each hostname should use exactly 1 worker. The below code
-	// creates a map to assign each hostname to a worker and does a roundrobin assignment to workers. If hostnames were not sticky to
-	// a given worker we could instead use a worker pool.
-	hostNameAssignment := make(map[string]int)
-	nextAssignement := 0
+	rows, err := loadInputRows(*fileName, *useStdIn)
+	if err != nil {
+		fmt.Printf("%s\n", err)
+		return
+	}
 
-	// We need a channel for each worker to pass work and a channel to return stats.
-	inputChans := make([]chan Input, numThreads)
-	statsChan := make([]chan Stats, numThreads)
-	for i := 0; i < numThreads; i++ {
-		inputChans[i] = make(chan Input)
-		statsChan[i] = make(chan Stats)
-		go workerFunction(inputChans[i], statsChan[i])
+	// On the first SIGINT/SIGTERM stop dispatching new input and fall through to printing
+	// whatever stats were collected so far. A second signal hard-exits immediately,
+	// for the case where something is still hanging (e.g. a stuck db connection).
+	var interrupted atomic.Bool
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		fmt.Println("\nInterrupted, finishing in-flight queries and printing partial results. Press again to exit immediately.")
+		interrupted.Store(true)
+		<-sigChan
+		fmt.Println("\nInterrupted again, exiting immediately.")
+		os.Exit(130)
+	}()
+
+	// -hist needs the raw per-query latencies to bucket, which the digest doesn't
+	// keep, so it implies exact collection rather than silently printing nothing.
+	statsCfg := statsConfig{
+		exact:       *exactStats || *hist,
+		compression: *compression,
 	}
 
-	var r *csv.Reader
-	processStartTime := time.Now()
-	if *useStdIn {
-		r = csv.NewReader(bufio.NewReader(os.Stdin))
-	} else {
-		reader, err := os.Open(*fileName)
-		if err != nil {
-			fmt.Printf("Unable to open input file %s\nError: %s\n", *fileName, err)
-			return
-		}
-		defer reader.Close()
-		r = csv.NewReader(reader)
+	if *sweep {
+		runSweep(rows, backendCfg, statsCfg, &interrupted)
+		return
 	}
 
-	// Read first row and drop it since its the header.
-	firstRow, err := r.Read()
+	numThreads, err := parseWorkerCount(*workersFlag)
 	if err != nil {
-		fmt.Printf("error reading input file %s\n", err.Error())
-		return
-	} else if firstRow[0] != "hostname" {
-		fmt.Println("Expected first time of input file to be header")
+		fmt.Println(err)
 		return
 	}
-
-	var input Input
-
-	stop := false
-	for !stop {
-		row, err := r.Read()
-		if err == io.EOF {
-			stop = true
-		} else if err != nil {
-			fmt.Printf("Error reading file: %s\n", err)
-			return
-		} else {
-
-			input.hostname = row[0]
-			input.starttime, err = time.Parse(time.DateTime, row[1])
-			if err != nil {
-				fmt.Printf("Error parsing date: %s\nError: %s\n", row[1], err)
-				return
-			}
-			input.endtime, err = time.Parse(time.DateTime, row[2])
-			if err != nil {
-				fmt.Printf("Error parsing date: %s\nError: %s\n", row[2], err)
-				return
-			}
-
-			// Check if hostname has been assigned. If it hasn't assign it.
-			assignment, found := hostNameAssignment[input.hostname]
-			if !found {
-				assignment = nextAssignement
-				nextAssignement = (nextAssignement + 1) % numThreads
-				hostNameAssignment[input.hostname] = assignment
-			}
-			inputChans[assignment] <- input
-		}
+	if numThreads <= 0 {
+		fmt.Println("workers must be a positive number")
+		return
 	}
 
-	// Close each channel
-	for i := 0; i < numThreads; i++ {
-		close(inputChans[i])
-	}
+	statsAgg, totalProcessTime := runBenchmark(rows, numThreads, backendCfg, statsCfg, &interrupted)
 
-	// Get stats from worker and combine them
-	var statsAgg Stats
-	for i := 0; i < numThreads; i++ {
-		tempStats := <-statsChan[i]
-		statsAgg.totalQueries = tempStats.totalQueries
-		statsAgg.totalTime = tempStats.totalTime
-		statsAgg.queryTimes = tempStats.queryTimes
+	if interrupted.Load() {
+		fmt.Println("\n=== PARTIAL RESULTS (interrupted before all input was processed) ===")
 	}
 
-	totalProcessTime := time.Since(processStartTime)
-
-	// Aggregate stats using library.
 	// I included P90 and P95 because I have seen that they are often very useful when looking into performance.
-	// Ignore errors since we know the incoming data is okay and its not worth the extra code.
-	data := stats.LoadRawData(statsAgg.queryTimes)
-	min, _ := data.Min()
-	median, _ := data.Median()
-	mean, _ := data.Mean()
-	p90, _ := data.Percentile(90)
-	p95, _ := data.Percentile(95)
-	max, _ := data.Max()
+	sum := computeSummary(statsAgg, statsCfg)
 
 	// output stats
 	fmt.Printf("Total Runtime : %d ms\n", totalProcessTime.Milliseconds())
 	fmt.Printf("Total Queries: %d\n", statsAgg.totalQueries)
 	fmt.Printf("Total Query Execution time : %d ms\n", statsAgg.totalTime.Milliseconds())
-	fmt.Printf("Average Execution time : %.2f ms\n", mean/NANO_TO_MS)
-	fmt.Printf("Min    : %.2f ms\n", min/NANO_TO_MS)
-	fmt.Printf("Median : %.2f ms\n", median/NANO_TO_MS)
-	fmt.Printf("P90    : %.2f ms\n", p90/NANO_TO_MS)
-	fmt.Printf("P95    : %.2f ms\n", p95/NANO_TO_MS)
-	fmt.Printf("Max    : %.2f ms\n", max/NANO_TO_MS)
+	fmt.Printf("Average Execution time : %.2f ms\n", sum.mean/NANO_TO_MS)
+	fmt.Printf("Min    : %.2f ms\n", sum.min/NANO_TO_MS)
+	fmt.Printf("Median : %.2f ms\n", sum.median/NANO_TO_MS)
+	fmt.Printf("P90    : %.2f ms\n", sum.p90/NANO_TO_MS)
+	fmt.Printf("P95    : %.2f ms\n", sum.p95/NANO_TO_MS)
+	fmt.Printf("Max    : %.2f ms\n", sum.max/NANO_TO_MS)
+	fmt.Printf("P50    : %.2f ms\n", sum.p50/NANO_TO_MS)
+	fmt.Printf("P75    : %.2f ms\n", sum.p75/NANO_TO_MS)
+	fmt.Printf("P99    : %.2f ms\n", sum.p99/NANO_TO_MS)
+	fmt.Printf("P99.9  : %.2f ms\n", sum.p999/NANO_TO_MS)
+
+	if *hist {
+		printHistogram(statsAgg.queryTimes, *histBuckets)
+	}
+
+	if interrupted.Load() {
+		os.Exit(1)
+	}
 }
 
-func workerFunction(inputChan <-chan Input, statsChan chan<- Stats) {
+// buildDSN builds the postgres connection string from the hardcoded db info above.
+// Pulled out so the benchmark workers and the bulk loader don't duplicate it.
+func buildDSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+}
+
+func workerFunction(inputChan <-chan Input, statsChan chan<- Stats, cfg backendConfig, statsCfg statsConfig) {
 	// Create stats setup
 	var stats Stats
-
-	// Create db connection: Given this is for a CLI each worker can create and reuse its own connection. If this grows we might want to use a
-	// db connection pool of some sort.
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		fmt.Printf("Unable to connect to db. Please check settings match db\nError: %s\n", err)
-		panic(err)
+	if !statsCfg.exact {
+		stats.digest = newTDigest(statsCfg.compression)
 	}
-	defer db.Close()
 
-	// Warm up db connection to improve max process time.
-	err = db.Ping()
+	// Each worker builds and reuses its own Querier. If this grows we might want to use a
+	// connection pool of some sort instead.
+	querier, err := newQuerier(cfg)
 	if err != nil {
-		fmt.Printf("Unable to ping db. Please check settings match db\nError: %s\n", err)
+		fmt.Printf("Unable to set up %s querier\nError: %s\n", cfg.backend, err)
 		panic(err)
 	}
+	defer querier.Close()
 
 	// spin until done
 	for {
@@ -207,20 +201,19 @@ func workerFunction(inputChan <-chan Input, statsChan chan<- Stats) {
 		input, more := <-inputChan
 		if more {
 			start = time.Now()
-			row := db.QueryRow(fmt.Sprintf(QUERY_TEMPLATE, input.hostname, input.starttime.Format(time.DateTime), input.endtime.Format(time.DateTime)))
+			_, _, err := querier.Query(input.hostname, input.starttime, input.endtime)
 			length = time.Since(start)
-
-			// Scan the row and check for error but we don't care about the specific result at this time.
-			var min *float64
-			var max *float64
-			err = row.Scan(&min, &max)
 			if err != nil {
-				fmt.Println("Unable to scan row correctly")
+				fmt.Println("Unable to run query correctly")
 				panic(err)
 			}
 			stats.totalQueries += 1
-			stats.queryTimes = append(stats.queryTimes, length)
 			stats.totalTime += length
+			if statsCfg.exact {
+				stats.queryTimes = append(stats.queryTimes, length)
+			} else {
+				stats.digest.Add(float64(length), 1)
+			}
 		} else {
 			// return stats and finish
 			statsChan <- stats
@@ -229,63 +222,3 @@ func workerFunction(inputChan <-chan Input, statsChan chan<- Stats) {
 
 	}
 }
-
-// I was developing on a linux VM on my windows laptop and ran into problems getting a docker image with psql fitting on the virtual disk
-// instead of reimaging the OS on a bigger disk to be able to use psql to inport the input csv I decided to do it this way. There is probably a better/faster
-// way to implement the inport. It takes a bit of time to run but it works.
-func addDataToDB() {
-	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s "+"password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
-	db, err := sql.Open("postgres", psqlInfo)
-	if err != nil {
-		fmt.Printf("Unable to connect to db. Please check settings match db\nError: %s\n", err)
-		panic(err)
-	}
-	defer db.Close()
-
-	reader, err := os.Open("/app/input/cpu_usage.csv")
-	if err != nil {
-		fmt.Printf("Unable to open input file %s\nError: %s\n", "/app/input/cpu_usage.csv", err)
-		return
-	}
-	defer reader.Close()
-	r := csv.NewReader(reader)
-
-	records, err := r.ReadAll()
-	if err != nil {
-		fmt.Printf("error reading input file %s\n", err.Error())
-		return
-	} else if records[0][0] != "ts" {
-		fmt.Println("Expected first time of input file to be header")
-		return
-	}
-
-	counter := 0
-	queryString := ""
-	for i, row := range records {
-		if i == 0 {
-			if row[0] != "ts" {
-				fmt.Println("Expected first time of input file to be header")
-			}
-		} else {
-			if counter == 0 {
-				queryString = "INSERT INTO cpu_usage VALUES ("
-			}
-			if counter < 10000 {
-				queryString += fmt.Sprintf("'%s', '%s', '%s'),(", row[0], row[1], row[2])
-				counter += 1
-			} else {
-				queryString += fmt.Sprintf("'%s', '%s', '%s')", row[0], row[1], row[2])
-				fmt.Printf("executing query %d\n", i)
-				_, err := db.Exec(queryString)
-				if err != nil {
-					panic(err)
-				}
-				counter = 0
-			}
-			_, err := db.Exec(fmt.Sprintf("INSERT INTO cpu_usage VALUES ('%s', '%s', %s)", row[0], row[1], row[2]))
-			if err != nil {
-				panic(err)
-			}
-		}
-	}
-}
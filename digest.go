@@ -0,0 +1,212 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+
+	"github.com/montanaflynn/stats"
+)
+
+// statsConfig controls how query latencies are aggregated: the exact raw-slice
+// mode montanaflynn/stats already gave us, or the streaming t-digest estimator
+// below. Exact mode is kept for small runs since it's precise; digest mode is
+// the default since it's what keeps memory bounded on million-query runs.
+type statsConfig struct {
+	exact       bool
+	compression float64
+}
+
+// tDigest is a streaming percentile estimator: it keeps a sorted set of
+// (mean, weight) centroids instead of every sample, merging new values into
+// the nearest centroid as long as that centroid stays under a size bound that
+// shrinks near the tails, so P99 stays accurate while P50 is allowed to be
+// coarser. See Dunning & Ertl, "Computing Extremely Accurate Quantiles Using
+// t-Digests".
+type tDigest struct {
+	compression float64
+	centroids   []centroid
+	count       float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+func newTDigest(compression float64) *tDigest {
+	if compression <= 0 {
+		compression = 100
+	}
+	return &tDigest{compression: compression}
+}
+
+// sizeBound is k(q) = 4 * N * q * (1-q) / delta, the max weight a centroid near
+// quantile q may carry before a new centroid has to be created instead. The
+// bound is inversely proportional to compression: higher delta means smaller,
+// more numerous centroids and a more accurate digest. (Getting this backwards
+// collapses the whole digest into a single centroid since every centroid looks
+// "under bound" no matter how big it gets.)
+func (t *tDigest) sizeBound(q float64) float64 {
+	return 4 * t.count * q * (1 - q) / t.compression
+}
+
+// Add folds a new sample into the nearest centroid, or creates a new one if
+// that centroid is already at its size bound.
+func (t *tDigest) Add(x, weight float64) {
+	if len(t.centroids) == 0 {
+		t.centroids = append(t.centroids, centroid{mean: x, weight: weight})
+		t.count += weight
+		return
+	}
+
+	best := 0
+	bestDist := math.MaxFloat64
+	cumulativeBeforeBest := 0.0
+	cumulative := 0.0
+	for i, c := range t.centroids {
+		d := math.Abs(c.mean - x)
+		if d < bestDist {
+			bestDist = d
+			best = i
+			cumulativeBeforeBest = cumulative
+		}
+		cumulative += c.weight
+	}
+
+	q := (cumulativeBeforeBest + t.centroids[best].weight/2) / (t.count + weight)
+	bound := t.sizeBound(q)
+
+	if t.centroids[best].weight+weight <= bound {
+		c := &t.centroids[best]
+		c.mean += weight * (x - c.mean) / (c.weight + weight)
+		c.weight += weight
+	} else {
+		t.centroids = append(t.centroids, centroid{mean: x, weight: weight})
+		sort.Slice(t.centroids, func(i, j int) bool { return t.centroids[i].mean < t.centroids[j].mean })
+	}
+	t.count += weight
+}
+
+// Merge combines another digest's centroids into this one by concatenating,
+// shuffling so insertion order doesn't bias the result, sorting by descending
+// weight, and re-adding them, which is the merge procedure the t-digest paper
+// recommends.
+func (t *tDigest) Merge(other *tDigest) {
+	if other == nil || len(other.centroids) == 0 {
+		return
+	}
+
+	merged := append(append([]centroid{}, t.centroids...), other.centroids...)
+	t.centroids = nil
+	t.count = 0
+	rand.Shuffle(len(merged), func(i, j int) { merged[i], merged[j] = merged[j], merged[i] })
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].weight > merged[j].weight })
+	for _, c := range merged {
+		t.Add(c.mean, c.weight)
+	}
+}
+
+// Quantile interpolates the value at quantile q (0-1) across the centroids'
+// cumulative weights.
+func (t *tDigest) Quantile(q float64) float64 {
+	if len(t.centroids) == 0 {
+		return 0
+	}
+	if len(t.centroids) == 1 {
+		return t.centroids[0].mean
+	}
+
+	target := q * t.count
+	cumulative := 0.0
+	for i, c := range t.centroids {
+		next := cumulative + c.weight
+		if target <= next || i == len(t.centroids)-1 {
+			if i == 0 {
+				return c.mean
+			}
+			prev := t.centroids[i-1]
+			prevCum := cumulative - prev.weight/2
+			curCum := cumulative + c.weight/2
+			if curCum == prevCum {
+				return c.mean
+			}
+			frac := (target - prevCum) / (curCum - prevCum)
+			return prev.mean + frac*(c.mean-prev.mean)
+		}
+		cumulative = next
+	}
+	return t.centroids[len(t.centroids)-1].mean
+}
+
+// Mean returns the weighted average of every centroid, which is exact
+// regardless of compression since no samples were ever dropped, only merged.
+func (t *tDigest) Mean() float64 {
+	if t.count == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, c := range t.centroids {
+		sum += c.mean * c.weight
+	}
+	return sum / t.count
+}
+
+// Min and Max are approximate: the smallest/largest centroid mean, not the
+// true extreme sample, since the digest never keeps individual samples.
+func (t *tDigest) Min() float64 {
+	if len(t.centroids) == 0 {
+		return 0
+	}
+	return t.centroids[0].mean
+}
+
+func (t *tDigest) Max() float64 {
+	if len(t.centroids) == 0 {
+		return 0
+	}
+	return t.centroids[len(t.centroids)-1].mean
+}
+
+// summary is the set of latency stats printed after a run, regardless of
+// whether they came from the exact raw-slice aggregator or the digest.
+type summary struct {
+	min, median, mean                  float64
+	p50, p75, p90, p95, p99, p999, max float64
+}
+
+// computeSummary builds a summary from a Stats, using the raw queryTimes
+// slice in exact mode and the merged digest otherwise. Errors from the stats
+// library are ignored in exact mode since the incoming data is known-good.
+func computeSummary(s Stats, cfg statsConfig) summary {
+	if cfg.exact {
+		data := stats.LoadRawData(s.queryTimes)
+		min, _ := data.Min()
+		median, _ := data.Median()
+		mean, _ := data.Mean()
+		p50, _ := data.Percentile(50)
+		p75, _ := data.Percentile(75)
+		p90, _ := data.Percentile(90)
+		p95, _ := data.Percentile(95)
+		p99, _ := data.Percentile(99)
+		p999, _ := data.Percentile(99.9)
+		max, _ := data.Max()
+		return summary{min: min, median: median, mean: mean, p50: p50, p75: p75, p90: p90, p95: p95, p99: p99, p999: p999, max: max}
+	}
+
+	if s.digest == nil {
+		return summary{}
+	}
+	return summary{
+		min:    s.digest.Min(),
+		median: s.digest.Quantile(0.5),
+		mean:   s.digest.Mean(),
+		p50:    s.digest.Quantile(0.5),
+		p75:    s.digest.Quantile(0.75),
+		p90:    s.digest.Quantile(0.9),
+		p95:    s.digest.Quantile(0.95),
+		p99:    s.digest.Quantile(0.99),
+		p999:   s.digest.Quantile(0.999),
+		max:    s.digest.Max(),
+	}
+}
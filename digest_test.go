@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// approxEqual allows the digest's estimate to be off by a small relative
+// tolerance, since it trades a bit of accuracy for O(1) memory per update.
+func approxEqual(t *testing.T, name string, got, want, tolerance float64) {
+	t.Helper()
+	if math.Abs(got-want) > tolerance*math.Abs(want) {
+		t.Errorf("%s = %v, want ~%v (tolerance %.1f%%)", name, got, want, tolerance*100)
+	}
+}
+
+func TestTDigestQuantilesUniform(t *testing.T) {
+	// 0..99999, shuffled, so insertion order doesn't favor the estimator.
+	const n = 100000
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = float64(i)
+	}
+	rand.Shuffle(n, func(i, j int) { values[i], values[j] = values[j], values[i] })
+
+	d := newTDigest(100)
+	for _, v := range values {
+		d.Add(v, 1)
+	}
+
+	if got := len(d.centroids); got < 2 {
+		t.Fatalf("digest collapsed to %d centroid(s), expected many more", got)
+	}
+
+	cases := []struct {
+		name string
+		q    float64
+		want float64
+	}{
+		{"p50", 0.5, float64(n) * 0.5},
+		{"p90", 0.9, float64(n) * 0.9},
+		{"p95", 0.95, float64(n) * 0.95},
+		{"p99", 0.99, float64(n) * 0.99},
+	}
+	for _, c := range cases {
+		approxEqual(t, c.name, d.Quantile(c.q), c.want, 0.02)
+	}
+}
+
+func TestTDigestMean(t *testing.T) {
+	d := newTDigest(100)
+	for _, v := range []float64{1, 2, 3, 4, 5} {
+		d.Add(v, 1)
+	}
+	approxEqual(t, "mean", d.Mean(), 3, 0.01)
+}
+
+func TestTDigestMerge(t *testing.T) {
+	a := newTDigest(100)
+	b := newTDigest(100)
+	for i := 0; i < 500; i++ {
+		a.Add(float64(i), 1)
+	}
+	for i := 500; i < 1000; i++ {
+		b.Add(float64(i), 1)
+	}
+
+	a.Merge(b)
+	approxEqual(t, "merged median", a.Quantile(0.5), 500, 0.05)
+}
+
+func TestTDigestEmpty(t *testing.T) {
+	d := newTDigest(100)
+	if got := d.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile on empty digest = %v, want 0", got)
+	}
+	if got := d.Mean(); got != 0 {
+		t.Errorf("Mean on empty digest = %v, want 0", got)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// printHistogram buckets query latencies into log-spaced buckets (similar to
+// what boom/hey print for load tests) and renders an ASCII bar per bucket
+// scaled to the largest bucket's count, so the tail of the distribution is
+// visible instead of just the summary points above.
+func printHistogram(durations []time.Duration, buckets int) {
+	if len(durations) == 0 || buckets <= 0 {
+		return
+	}
+
+	fmt.Println("\nLatency histogram:")
+
+	minMs, maxMs := minMaxMs(durations)
+	if minMs == maxMs {
+		fmt.Printf("  %.2f ms [%d]\t%s\n", minMs, len(durations), strings.Repeat("#", 40))
+		return
+	}
+
+	counts, logMin, step := bucketDurations(durations, buckets, minMs, maxMs)
+
+	maxCount := 0
+	for _, c := range counts {
+		if c > maxCount {
+			maxCount = c
+		}
+	}
+
+	const barWidth = 40
+	for i, c := range counts {
+		edge := math.Pow(10, logMin+step*float64(i+1))
+		barLen := 0
+		if maxCount > 0 {
+			barLen = c * barWidth / maxCount
+		}
+		fmt.Printf("  <= %-10.2f ms [%6d]\t%s\n", edge, c, strings.Repeat("#", barLen))
+	}
+}
+
+// minMaxMs returns the min and max of durations, in milliseconds.
+func minMaxMs(durations []time.Duration) (min, max float64) {
+	min = math.MaxFloat64
+	max = -math.MaxFloat64
+	for _, d := range durations {
+		ms := float64(d) / NANO_TO_MS
+		if ms < min {
+			min = ms
+		}
+		if ms > max {
+			max = ms
+		}
+	}
+	return min, max
+}
+
+// bucketDurations buckets durations into log-spaced buckets between minMs and
+// maxMs and returns the per-bucket counts, plus the logMin/step used to
+// compute bucket edges, so a long tail doesn't squash the fast buckets into a
+// single bar. minMs is floored at a small epsilon since log(0) is undefined
+// and latencies can legitimately be ~0ms.
+func bucketDurations(durations []time.Duration, buckets int, minMs, maxMs float64) (counts []int, logMin, step float64) {
+	const epsilon = 0.001
+	logMin = math.Log10(math.Max(minMs, epsilon))
+	logMax := math.Log10(math.Max(maxMs, epsilon))
+	step = (logMax - logMin) / float64(buckets)
+
+	counts = make([]int, buckets)
+	for _, d := range durations {
+		ms := math.Max(float64(d)/NANO_TO_MS, epsilon)
+		idx := int((math.Log10(ms) - logMin) / step)
+		if idx < 0 {
+			idx = 0
+		} else if idx >= buckets {
+			idx = buckets - 1
+		}
+		counts[idx]++
+	}
+	return counts, logMin, step
+}
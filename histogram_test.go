@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinMaxMs(t *testing.T) {
+	durations := []time.Duration{
+		5 * time.Millisecond,
+		1 * time.Millisecond,
+		50 * time.Millisecond,
+	}
+	min, max := minMaxMs(durations)
+	if min != 1 {
+		t.Errorf("min = %v, want 1", min)
+	}
+	if max != 50 {
+		t.Errorf("max = %v, want 50", max)
+	}
+}
+
+func TestBucketDurations(t *testing.T) {
+	cases := []struct {
+		name      string
+		durations []time.Duration
+		buckets   int
+		wantTotal int
+	}{
+		{
+			name: "all in one bucket",
+			durations: []time.Duration{
+				10 * time.Millisecond,
+				10 * time.Millisecond,
+				10 * time.Millisecond,
+			},
+			buckets:   10,
+			wantTotal: 3,
+		},
+		{
+			name: "spread across the range",
+			durations: []time.Duration{
+				1 * time.Millisecond,
+				10 * time.Millisecond,
+				100 * time.Millisecond,
+				1000 * time.Millisecond,
+			},
+			buckets:   10,
+			wantTotal: 4,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			min, max := minMaxMs(c.durations)
+			counts, _, _ := bucketDurations(c.durations, c.buckets, min, max)
+
+			if len(counts) != c.buckets {
+				t.Fatalf("len(counts) = %d, want %d", len(counts), c.buckets)
+			}
+
+			total := 0
+			for i, count := range counts {
+				if count < 0 {
+					t.Errorf("bucket %d has negative count %d", i, count)
+				}
+				total += count
+			}
+			if total != c.wantTotal {
+				t.Errorf("total bucketed = %d, want %d", total, c.wantTotal)
+			}
+		})
+	}
+}
+
+func TestBucketDurationsFastAndSlowSeparate(t *testing.T) {
+	// A fast cluster and a slow outlier should land in different buckets
+	// rather than being lumped into a single bar.
+	durations := []time.Duration{
+		1 * time.Millisecond,
+		1 * time.Millisecond,
+		1 * time.Millisecond,
+		1000 * time.Millisecond,
+	}
+	min, max := minMaxMs(durations)
+	counts, _, _ := bucketDurations(durations, 10, min, max)
+
+	nonEmpty := 0
+	for _, c := range counts {
+		if c > 0 {
+			nonEmpty++
+		}
+	}
+	if nonEmpty < 2 {
+		t.Errorf("expected the fast cluster and the slow outlier in different buckets, got %d non-empty bucket(s)", nonEmpty)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// loadInputRows reads the query_params.csv input (from fileName, or stdin if
+// useStdIn is set) fully into memory. Loading everything up front, instead of
+// streaming rows straight into worker channels as they're read, is what lets
+// -sweep replay the exact same input against several worker counts.
+func loadInputRows(fileName string, useStdIn bool) ([]Input, error) {
+	var r *csv.Reader
+	if useStdIn {
+		r = csv.NewReader(bufio.NewReader(os.Stdin))
+	} else {
+		reader, err := os.Open(fileName)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open input file %s: %w", fileName, err)
+		}
+		defer reader.Close()
+		r = csv.NewReader(reader)
+	}
+
+	// Read first row and drop it since its the header.
+	firstRow, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("error reading input file %s: %w", fileName, err)
+	} else if firstRow[0] != "hostname" {
+		return nil, errors.New("expected first row of input file to be header")
+	}
+
+	var rows []Input
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+
+		var input Input
+		input.hostname = row[0]
+		input.starttime, err = time.Parse(time.DateTime, row[1])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date %s: %w", row[1], err)
+		}
+		input.endtime, err = time.Parse(time.DateTime, row[2])
+		if err != nil {
+			return nil, fmt.Errorf("error parsing date %s: %w", row[2], err)
+		}
+		rows = append(rows, input)
+	}
+	return rows, nil
+}
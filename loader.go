@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// bulkLoadConfig holds the settings for the parallel COPY-based loader that
+// replaced the old single-goroutine addDataToDB.
+type bulkLoadConfig struct {
+	inputFile  string
+	workers    int
+	batchSize  int
+	skipHeader bool
+	dryRun     bool
+	truncate   bool
+}
+
+// rowBatch is a chunk of CSV rows handed to a single worker.
+type rowBatch struct {
+	rows [][]string
+}
+
+// loaderResult is what each worker reports back once the batch channel closes.
+// failedBatches and err accumulate across every batch that failed, rather
+// than just the last one, so a worker that drops several batches doesn't
+// silently under-report how much went wrong.
+type loaderResult struct {
+	rowsLoaded    int64
+	failedBatches int
+	err           error
+}
+
+// batcher accumulates CSV rows and hands them back in batchSize chunks. Pulled
+// out of runBulkLoad's read loop so the chunking logic can be unit tested
+// without a CSV file or a db.
+type batcher struct {
+	batchSize int
+	current   [][]string
+}
+
+func newBatcher(batchSize int) *batcher {
+	return &batcher{batchSize: batchSize, current: make([][]string, 0, batchSize)}
+}
+
+// add appends a row and returns a full batch, if one just filled up.
+func (b *batcher) add(row []string) (rowBatch, bool) {
+	b.current = append(b.current, row)
+	if len(b.current) < b.batchSize {
+		return rowBatch{}, false
+	}
+	batch := rowBatch{rows: b.current}
+	b.current = make([][]string, 0, b.batchSize)
+	return batch, true
+}
+
+// flush returns whatever partial batch is left once the input is exhausted.
+func (b *batcher) flush() (rowBatch, bool) {
+	if len(b.current) == 0 {
+		return rowBatch{}, false
+	}
+	batch := rowBatch{rows: b.current}
+	b.current = nil
+	return batch, true
+}
+
+// runBulkLoad streams /app/input/cpu_usage.csv into cpu_usage using a pool of
+// workers, each holding its own *sql.DB and its own COPY FROM STDIN statement
+// (via pq.CopyIn), the same approach timescaledb-parallel-copy uses. This
+// replaces the old addDataToDB, which built giant multi-row INSERT strings on
+// a single goroutine and, due to a leftover debug db.Exec, inserted every row
+// twice.
+func runBulkLoad(cfg bulkLoadConfig) error {
+	if cfg.workers <= 0 {
+		return errors.New("loader-workers must be a positive number")
+	}
+	if cfg.batchSize <= 0 {
+		return errors.New("batch-size must be a positive number")
+	}
+
+	reader, err := os.Open(cfg.inputFile)
+	if err != nil {
+		return fmt.Errorf("unable to open input file %s: %w", cfg.inputFile, err)
+	}
+	defer reader.Close()
+
+	r := csv.NewReader(bufio.NewReader(reader))
+
+	if cfg.skipHeader {
+		header, err := r.Read()
+		if err != nil {
+			return fmt.Errorf("error reading input file %s: %w", cfg.inputFile, err)
+		} else if header[0] != "ts" {
+			return errors.New("expected first row of input file to be header")
+		}
+	}
+
+	if cfg.truncate {
+		if cfg.dryRun {
+			fmt.Println("dry run: skipping truncate of cpu_usage")
+		} else if err := truncateCPUUsage(); err != nil {
+			return err
+		}
+	}
+
+	batches := make(chan rowBatch, cfg.workers*2)
+	results := make(chan loaderResult, cfg.workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.workers; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			results <- loadWorker(id, batches, cfg.dryRun)
+		}(i)
+	}
+
+	start := time.Now()
+	var totalRows int64
+	b := newBatcher(cfg.batchSize)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			close(batches)
+			wg.Wait()
+			return fmt.Errorf("error reading input file: %w", err)
+		}
+
+		totalRows++
+		if batch, ok := b.add(row); ok {
+			batches <- batch
+		}
+	}
+	if batch, ok := b.flush(); ok {
+		batches <- batch
+	}
+	close(batches)
+
+	wg.Wait()
+	close(results)
+	elapsed := time.Since(start)
+
+	var rowsLoaded int64
+	var failedBatches int
+	var workerErrs []error
+	for res := range results {
+		rowsLoaded += res.rowsLoaded
+		failedBatches += res.failedBatches
+		if res.err != nil {
+			workerErrs = append(workerErrs, res.err)
+		}
+	}
+
+	rowsPerSec := float64(0)
+	if elapsed.Seconds() > 0 {
+		rowsPerSec = float64(rowsLoaded) / elapsed.Seconds()
+	}
+
+	fmt.Printf("Total rows read   : %d\n", totalRows)
+	fmt.Printf("Total rows loaded : %d\n", rowsLoaded)
+	fmt.Printf("Total time        : %s\n", elapsed)
+	fmt.Printf("Rows/sec          : %.2f\n", rowsPerSec)
+	if cfg.dryRun {
+		fmt.Println("Dry run: no rows were written to the db")
+	}
+	if failedBatches > 0 {
+		fmt.Printf("Failed batches : %d\n", failedBatches)
+	}
+	for _, e := range workerErrs {
+		fmt.Printf("Worker error: %s\n", e)
+	}
+	if len(workerErrs) > 0 {
+		return fmt.Errorf("%d worker(s) reported errors across %d failed batch(es), see above", len(workerErrs), failedBatches)
+	}
+	return nil
+}
+
+// truncateCPUUsage empties cpu_usage before a fresh bulk load.
+func truncateCPUUsage() error {
+	db, err := sql.Open("postgres", buildDSN())
+	if err != nil {
+		return fmt.Errorf("unable to connect to db: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("TRUNCATE TABLE cpu_usage"); err != nil {
+		return fmt.Errorf("unable to truncate cpu_usage: %w", err)
+	}
+	return nil
+}
+
+// loadWorker owns a single db connection and COPYs its batches into cpu_usage
+// until the batches channel closes. In dry-run mode it just counts rows.
+func loadWorker(id int, batches <-chan rowBatch, dryRun bool) loaderResult {
+	var res loaderResult
+
+	if dryRun {
+		for b := range batches {
+			res.rowsLoaded += int64(len(b.rows))
+		}
+		return res
+	}
+
+	db, err := sql.Open("postgres", buildDSN())
+	if err != nil {
+		res.err = fmt.Errorf("worker %d: unable to connect to db: %w", id, err)
+		// Drain so the batch channel doesn't block the readers feeding it.
+		for range batches {
+		}
+		return res
+	}
+	defer db.Close()
+
+	for b := range batches {
+		if err := copyBatch(db, b); err != nil {
+			res.failedBatches++
+			res.err = errors.Join(res.err, fmt.Errorf("worker %d: %w", id, err))
+			continue
+		}
+		res.rowsLoaded += int64(len(b.rows))
+	}
+	return res
+}
+
+// copyBatch streams a single batch of rows into cpu_usage via COPY FROM STDIN,
+// which is an order of magnitude faster than the batched INSERTs it replaces.
+func copyBatch(db *sql.DB, b rowBatch) error {
+	txn, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("unable to start transaction: %w", err)
+	}
+
+	stmt, err := txn.Prepare(pq.CopyIn("cpu_usage", "ts", "host", "usage"))
+	if err != nil {
+		txn.Rollback()
+		return fmt.Errorf("unable to prepare copy statement: %w", err)
+	}
+
+	for _, row := range b.rows {
+		usage, err := strconv.ParseFloat(row[2], 64)
+		if err != nil {
+			stmt.Close()
+			txn.Rollback()
+			return fmt.Errorf("unable to parse usage %q: %w", row[2], err)
+		}
+		if _, err := stmt.Exec(row[0], row[1], usage); err != nil {
+			stmt.Close()
+			txn.Rollback()
+			return fmt.Errorf("unable to copy row: %w", err)
+		}
+	}
+
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		txn.Rollback()
+		return fmt.Errorf("unable to flush copy: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		txn.Rollback()
+		return fmt.Errorf("unable to close copy statement: %w", err)
+	}
+	return txn.Commit()
+}
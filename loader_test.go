@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatcher(t *testing.T) {
+	b := newBatcher(2)
+
+	if _, ok := b.add([]string{"1"}); ok {
+		t.Fatal("add returned a batch before batchSize rows were added")
+	}
+	batch, ok := b.add([]string{"2"})
+	if !ok {
+		t.Fatal("add did not return a batch once batchSize rows were added")
+	}
+	if len(batch.rows) != 2 {
+		t.Errorf("len(batch.rows) = %d, want 2", len(batch.rows))
+	}
+
+	if _, ok := b.flush(); ok {
+		t.Fatal("flush returned a batch with no pending rows")
+	}
+
+	b.add([]string{"3"})
+	batch, ok = b.flush()
+	if !ok {
+		t.Fatal("flush did not return the trailing partial batch")
+	}
+	if len(batch.rows) != 1 {
+		t.Errorf("len(batch.rows) = %d, want 1", len(batch.rows))
+	}
+}
+
+func TestLoadWorkerDryRun(t *testing.T) {
+	batches := make(chan rowBatch, 3)
+	batches <- rowBatch{rows: [][]string{{"a"}, {"b"}}}
+	batches <- rowBatch{rows: [][]string{{"c"}}}
+	close(batches)
+
+	res := loadWorker(0, batches, true)
+	if res.rowsLoaded != 3 {
+		t.Errorf("rowsLoaded = %d, want 3", res.rowsLoaded)
+	}
+	if res.err != nil {
+		t.Errorf("err = %v, want nil", res.err)
+	}
+	if res.failedBatches != 0 {
+		t.Errorf("failedBatches = %d, want 0", res.failedBatches)
+	}
+}
+
+func TestParseWorkerCount(t *testing.T) {
+	if n, err := parseWorkerCount("auto"); err != nil || n <= 0 {
+		t.Errorf("parseWorkerCount(auto) = (%d, %v), want a positive count and no error", n, err)
+	}
+	if n, err := parseWorkerCount("4"); err != nil || n != 4 {
+		t.Errorf("parseWorkerCount(4) = (%d, %v), want (4, nil)", n, err)
+	}
+	if _, err := parseWorkerCount("not-a-number"); err == nil {
+		t.Error("parseWorkerCount(not-a-number) returned nil error, want an error")
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	influx "github.com/influxdata/influxdb1-client/v2"
+)
+
+// backendConfig carries the -backend selection and the connection settings
+// for whichever backend was picked. Each worker builds its own Querier from
+// this so the existing one-hostname-per-worker sharding is preserved.
+type backendConfig struct {
+	backend   string
+	pgDSN     string
+	influxURL string
+	influxDB  string
+}
+
+// Querier is the thing a worker asks for min/max usage over a time range.
+// Implementing this for a new time-series db is the only thing needed to
+// benchmark it with the rest of this tool's pipeline (CSV input, stats
+// aggregation) unchanged.
+type Querier interface {
+	Query(hostname string, start, end time.Time) (min, max float64, err error)
+	Close() error
+}
+
+// newQuerier builds the Querier for cfg.backend. Each worker calls this once
+// up front and reuses the connection for every query it runs.
+func newQuerier(cfg backendConfig) (Querier, error) {
+	switch cfg.backend {
+	case "", "postgres", "timescaledb":
+		return newPostgresQuerier(cfg.pgDSN)
+	case "influx", "influxdb":
+		return newInfluxQuerier(cfg.influxURL, cfg.influxDB)
+	default:
+		return nil, fmt.Errorf("unknown backend %q", cfg.backend)
+	}
+}
+
+// postgresQuerier is the original behavior: a single min/max query against
+// cpu_usage over lib/pq.
+type postgresQuerier struct {
+	db *sql.DB
+}
+
+func newPostgresQuerier(dsn string) (Querier, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to db: %w", err)
+	}
+
+	// Warm up db connection to improve max process time.
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to ping db: %w", err)
+	}
+
+	return &postgresQuerier{db: db}, nil
+}
+
+func (q *postgresQuerier) Query(hostname string, start, end time.Time) (float64, float64, error) {
+	row := q.db.QueryRow(fmt.Sprintf(QUERY_TEMPLATE, hostname, start.Format(time.DateTime), end.Format(time.DateTime)))
+
+	// Scan the row and check for error but we don't care about the specific result at this time.
+	var min *float64
+	var max *float64
+	if err := row.Scan(&min, &max); err != nil {
+		return 0, 0, err
+	}
+
+	var minVal, maxVal float64
+	if min != nil {
+		minVal = *min
+	}
+	if max != nil {
+		maxVal = *max
+	}
+	return minVal, maxVal, nil
+}
+
+func (q *postgresQuerier) Close() error {
+	return q.db.Close()
+}
+
+// influxQuerier runs the InfluxQL equivalent of QUERY_TEMPLATE.
+const INFLUX_QUERY_TEMPLATE = "SELECT MIN(usage), MAX(usage) FROM cpu_usage WHERE host='%s' AND time >= '%s' AND time <= '%s'"
+
+type influxQuerier struct {
+	client influx.Client
+	db     string
+}
+
+func newInfluxQuerier(url, db string) (Querier, error) {
+	client, err := influx.NewHTTPClient(influx.HTTPConfig{Addr: url})
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to influx: %w", err)
+	}
+
+	if _, _, err := client.Ping(5 * time.Second); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("unable to ping influx: %w", err)
+	}
+
+	return &influxQuerier{client: client, db: db}, nil
+}
+
+func (q *influxQuerier) Query(hostname string, start, end time.Time) (float64, float64, error) {
+	queryString := fmt.Sprintf(INFLUX_QUERY_TEMPLATE, hostname, start.Format(time.RFC3339), end.Format(time.RFC3339))
+	resp, err := q.client.Query(influx.NewQuery(queryString, q.db, ""))
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.Error() != nil {
+		return 0, 0, resp.Error()
+	}
+
+	if len(resp.Results) == 0 || len(resp.Results[0].Series) == 0 || len(resp.Results[0].Series[0].Values) == 0 {
+		return 0, 0, nil
+	}
+
+	values := resp.Results[0].Series[0].Values[0]
+	if len(values) < 3 {
+		return 0, 0, fmt.Errorf("unexpected influx response: expected 3 columns (time, min, max), got %d", len(values))
+	}
+	min, err := influxValueToFloat(values[1])
+	if err != nil {
+		return 0, 0, err
+	}
+	max, err := influxValueToFloat(values[2])
+	if err != nil {
+		return 0, 0, err
+	}
+	return min, max, nil
+}
+
+func (q *influxQuerier) Close() error {
+	return q.client.Close()
+}
+
+// influxValueToFloat converts one of the interface{} values in an influx
+// query response (nil or a json.Number) into a float64.
+func influxValueToFloat(v interface{}) (float64, error) {
+	if v == nil {
+		return 0, nil
+	}
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case fmt.Stringer:
+		var f float64
+		_, err := fmt.Sscanf(n.String(), "%f", &f)
+		return f, err
+	default:
+		return 0, fmt.Errorf("unexpected influx value type %T", v)
+	}
+}